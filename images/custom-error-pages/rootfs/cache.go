@@ -0,0 +1,359 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// ErrorCacheSizeVar is the name of the environment variable holding the
+	// maximum number of rendered error assets (one entry per code/ext/encoding
+	// combination) kept in the in-process cache. A value of 0 disables the
+	// limit, so the cache is never evicted.
+	ErrorCacheSizeVar = "ERROR_CACHE_SIZE"
+
+	// defaultErrorCacheSize is used when ErrorCacheSizeVar is unset or invalid.
+	defaultErrorCacheSize = 256
+)
+
+// filenamePattern matches both exact status files ("404.html") and class
+// fallback files ("4xx.html"), capturing the leading digit, the rest of the
+// code (or the literal "xx") and the extension.
+var filenamePattern = regexp.MustCompile(`^(\d)(\d{2}|xx)(\.[a-zA-Z0-9]+)$`)
+
+// cacheKey identifies a cached, ready-to-serve error asset. code is either a
+// literal 3 digit status code ("404.html") or a class marker, the class
+// digit followed by two zeroes ("4xx.html" -> 400), so exact and class
+// fallback variants of the same class never collide.
+type cacheKey struct {
+	code     int
+	ext      string
+	encoding string
+}
+
+// cacheEntry is the cached representation of an error asset: its bytes, a
+// strong ETag computed from those bytes, and the ModTime used for
+// Last-Modified/If-Modified-Since handling.
+type cacheEntry struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+func newCacheEntry(data []byte, modTime time.Time) *cacheEntry {
+	sum := sha256.Sum256(data)
+	return &cacheEntry{
+		data:    data,
+		etag:    `"` + hex.EncodeToString(sum[:]) + `"`,
+		modTime: modTime,
+	}
+}
+
+// errorFileCache is an in-process LRU cache of rendered error assets. It is
+// prewarmed from disk at startup by prewarm and kept fresh by the fsnotify
+// watcher started in watchErrorFiles, so operators can hot-swap error pages
+// without restarting the pod.
+type errorFileCache struct {
+	mu      sync.Mutex
+	maxSize int
+	order   []cacheKey // least-recently-used first
+	entries map[cacheKey]*cacheEntry
+}
+
+func newErrorFileCache(maxSize int) *errorFileCache {
+	return &errorFileCache{
+		maxSize: maxSize,
+		entries: make(map[cacheKey]*cacheEntry),
+	}
+}
+
+func (c *errorFileCache) get(key cacheKey) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if ok {
+		c.touch(key)
+	}
+	return entry, ok
+}
+
+func (c *errorFileCache) set(key cacheKey, entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[key]; !exists && c.maxSize > 0 && len(c.entries) >= c.maxSize {
+		c.evictOldest()
+	}
+	c.entries[key] = entry
+	c.touch(key)
+}
+
+// invalidate drops every cached variant (all encodings) of code/ext, used
+// when the backing file on disk changes.
+func (c *errorFileCache) invalidate(code int, ext string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.entries {
+		if key.code == code && key.ext == ext {
+			delete(c.entries, key)
+			c.removeFromOrder(key)
+		}
+	}
+}
+
+// touch and evictOldest assume the caller holds c.mu.
+func (c *errorFileCache) touch(key cacheKey) {
+	c.removeFromOrder(key)
+	c.order = append(c.order, key)
+}
+
+func (c *errorFileCache) removeFromOrder(key cacheKey) {
+	for i, k := range c.order {
+		if k == key {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *errorFileCache) evictOldest() {
+	if len(c.order) == 0 {
+		return
+	}
+	oldest := c.order[0]
+	c.order = c.order[1:]
+	delete(c.entries, oldest)
+}
+
+// loadEntry returns the cached entry for key, loading and caching it from
+// file on a cache miss. When key.encoding names a content-coding without a
+// pre-compressed sibling on disk (file + preEncodedSuffixes[encoding]), the
+// raw file is compressed on the fly and the result cached under key so later
+// requests for the same variant are free. The returned encoding is the
+// coding the entry is actually stored under, which is "" whenever
+// compression was requested but could not be produced (e.g. compressBytes
+// failed) and the caller fell back to serving the raw bytes; callers must
+// use this value, not key.encoding, when setting Content-Encoding.
+func (c *errorFileCache) loadEntry(key cacheKey, file string) (entry *cacheEntry, encoding string, ok bool) {
+	if entry, ok := c.get(key); ok {
+		return entry, key.encoding, true
+	}
+
+	if key.encoding != "" {
+		if suffix, ok := preEncodedSuffixes[key.encoding]; ok {
+			if data, modTime, err := readFile(file + suffix); err == nil {
+				entry := newCacheEntry(data, modTime)
+				c.set(key, entry)
+				return entry, key.encoding, true
+			}
+		}
+	}
+
+	rawKey := cacheKey{code: key.code, ext: key.ext}
+	raw, ok := c.get(rawKey)
+	if !ok {
+		data, modTime, err := readFile(file)
+		if err != nil {
+			return nil, "", false
+		}
+		raw = newCacheEntry(data, modTime)
+		c.set(rawKey, raw)
+	}
+
+	if key.encoding == "" {
+		return raw, "", true
+	}
+
+	compressed, err := compressBytes(raw.data, key.encoding)
+	if err != nil {
+		log.Printf("unexpected error compressing %v as %v: %v", file, key.encoding, err)
+		return raw, "", true
+	}
+	entry = newCacheEntry(compressed, raw.modTime)
+	c.set(key, entry)
+	return entry, key.encoding, true
+}
+
+func readFile(name string) ([]byte, time.Time, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, info.ModTime(), nil
+}
+
+// parseErrorFilename extracts the cacheKey code/ext pair from a file base
+// name such as "404.html" (code 404) or "4xx.html" (class marker 400).
+func parseErrorFilename(name string) (code int, ext string, ok bool) {
+	m := filenamePattern.FindStringSubmatch(name)
+	if m == nil {
+		return 0, "", false
+	}
+
+	if m[2] == "xx" {
+		class, _ := strconv.Atoi(m[1])
+		return class * 100, m[3], true
+	}
+
+	full, _ := strconv.Atoi(m[1] + m[2])
+	return full, m[3], true
+}
+
+// prewarm scans path for error files and primes cache with their contents so
+// the first request after startup never pays a cold-cache disk read.
+func prewarm(cache *errorFileCache, path string) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		log.Printf("unable to prewarm error page cache from %v: %v", path, err)
+		return
+	}
+
+	for _, de := range entries {
+		if de.IsDir() {
+			continue
+		}
+
+		name := de.Name()
+		encoding := ""
+		for enc, suffix := range preEncodedSuffixes {
+			if strings.HasSuffix(name, suffix) {
+				encoding = enc
+				name = strings.TrimSuffix(name, suffix)
+				break
+			}
+		}
+
+		code, ext, ok := parseErrorFilename(name)
+		if !ok {
+			continue
+		}
+
+		file := filepath.Join(path, de.Name())
+		data, modTime, err := readFile(file)
+		if err != nil {
+			log.Printf("unexpected error prewarming %v: %v", file, err)
+			continue
+		}
+		cache.set(cacheKey{code: code, ext: ext, encoding: encoding}, newCacheEntry(data, modTime))
+	}
+}
+
+// watchErrorFiles invalidates cached entries (both the rendered-byte cache
+// and, via tc, the parsed-template cache) whenever the file backing them
+// changes on disk, so operators can hot-swap error pages without restarting
+// the pod. Failures to start the watcher are logged and otherwise ignored:
+// the cache still serves correctly, it just won't notice out-of-band edits
+// until the pod restarts.
+func watchErrorFiles(cache *errorFileCache, tc *templateCache, path string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("unable to watch %v for changes: %v", path, err)
+		return
+	}
+
+	if err := watcher.Add(path); err != nil {
+		log.Printf("unable to watch %v for changes: %v", path, err)
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+					continue
+				}
+
+				for _, tplExt := range templateExtensions {
+					if strings.HasSuffix(event.Name, tplExt) {
+						log.Printf("invalidating cached template %v: changed", event.Name)
+						tc.invalidate(event.Name)
+					}
+				}
+
+				name := filepath.Base(event.Name)
+				for _, suffix := range preEncodedSuffixes {
+					name = strings.TrimSuffix(name, suffix)
+				}
+				name = strings.TrimSuffix(name, ".tmpl")
+
+				code, ext, ok := parseErrorFilename(name)
+				if !ok {
+					continue
+				}
+
+				log.Printf("invalidating cached error page for code %v (%v): %v changed", code, ext, event.Name)
+				cache.invalidate(code, ext)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("error watching %v for changes: %v", path, err)
+			}
+		}
+	}()
+}
+
+// codeOverrideWriter rewrites the 200 OK that http.ServeContent writes for a
+// full, non-conditional response into the status code the error backend was
+// actually asked to return, while leaving the statuses ServeContent uses for
+// conditional requests and ranges (304, 206, 416) untouched.
+type codeOverrideWriter struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (w *codeOverrideWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if statusCode == http.StatusOK {
+		statusCode = w.code
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *codeOverrideWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
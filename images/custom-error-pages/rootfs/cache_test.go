@@ -0,0 +1,98 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorFileCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newErrorFileCache(2)
+
+	k1 := cacheKey{code: 404, ext: ".html"}
+	k2 := cacheKey{code: 500, ext: ".html"}
+	k3 := cacheKey{code: 503, ext: ".html"}
+
+	c.set(k1, newCacheEntry([]byte("404"), time.Now()))
+	c.set(k2, newCacheEntry([]byte("500"), time.Now()))
+
+	// Touch k1 so k2, not k1, is the least recently used entry.
+	if _, ok := c.get(k1); !ok {
+		t.Fatalf("expected k1 to be cached")
+	}
+
+	c.set(k3, newCacheEntry([]byte("503"), time.Now()))
+
+	if _, ok := c.get(k2); ok {
+		t.Errorf("expected k2 to have been evicted as least recently used")
+	}
+	if _, ok := c.get(k1); !ok {
+		t.Errorf("expected k1 to still be cached")
+	}
+	if _, ok := c.get(k3); !ok {
+		t.Errorf("expected k3 to still be cached")
+	}
+}
+
+func TestErrorFileCacheInvalidateDropsAllEncodingVariants(t *testing.T) {
+	c := newErrorFileCache(0)
+
+	plain := cacheKey{code: 404, ext: ".html"}
+	gzipped := cacheKey{code: 404, ext: ".html", encoding: "gzip"}
+	other := cacheKey{code: 500, ext: ".html"}
+
+	c.set(plain, newCacheEntry([]byte("plain"), time.Now()))
+	c.set(gzipped, newCacheEntry([]byte("gzipped"), time.Now()))
+	c.set(other, newCacheEntry([]byte("other"), time.Now()))
+
+	c.invalidate(404, ".html")
+
+	if _, ok := c.get(plain); ok {
+		t.Errorf("expected plain 404 entry to be invalidated")
+	}
+	if _, ok := c.get(gzipped); ok {
+		t.Errorf("expected gzip-encoded 404 entry to be invalidated too")
+	}
+	if _, ok := c.get(other); !ok {
+		t.Errorf("expected unrelated 500 entry to survive invalidation")
+	}
+}
+
+func TestParseErrorFilename(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		wantCode int
+		wantExt  string
+		wantOK   bool
+	}{
+		{"exact status file", "404.html", 404, ".html", true},
+		{"class fallback file", "5xx.json", 500, ".json", true},
+		{"not a status file", "favicon.ico", 0, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, ext, ok := parseErrorFilename(tt.file)
+			if ok != tt.wantOK || code != tt.wantCode || ext != tt.wantExt {
+				t.Errorf("parseErrorFilename(%q) = (%v, %v, %v), want (%v, %v, %v)",
+					tt.file, code, ext, ok, tt.wantCode, tt.wantExt, tt.wantOK)
+			}
+		})
+	}
+}
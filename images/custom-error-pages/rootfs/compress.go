@@ -0,0 +1,181 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// EncodingHeader name of the header used to negotiate the response encoding
+	EncodingHeader = "Accept-Encoding"
+
+	// ContentEncodingHeader name of the header that tells the client which
+	// encoding was applied to the response body
+	ContentEncodingHeader = "Content-Encoding"
+
+	// VaryHeader name of the header used to tell caches that the response
+	// varies depending on the value of another header
+	VaryHeader = "Vary"
+
+	// CompressionPreferenceVar is the name of the environment variable holding
+	// a comma separated, most-preferred-first list of content codings the
+	// handler is allowed to negotiate with clients.
+	CompressionPreferenceVar = "ERROR_COMPRESSION_PREFERENCE"
+)
+
+// defaultEncodingPreference is used when CompressionPreferenceVar is unset.
+var defaultEncodingPreference = []string{"zstd", "br", "gzip", "deflate"}
+
+// preEncodedSuffixes maps a content coding to the suffix a pre-compressed
+// asset is expected to carry on disk, e.g. "404.html.zst" for zstd.
+var preEncodedSuffixes = map[string]string{
+	"gzip":    ".gz",
+	"br":      ".br",
+	"zstd":    ".zst",
+	"deflate": ".zz",
+}
+
+var (
+	gzipWriterPool = sync.Pool{
+		New: func() interface{} { return gzip.NewWriter(io.Discard) },
+	}
+	deflateWriterPool = sync.Pool{
+		New: func() interface{} {
+			w, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+			return w
+		},
+	}
+	brotliWriterPool = sync.Pool{
+		New: func() interface{} { return brotli.NewWriter(io.Discard) },
+	}
+	zstdEncoderPool = sync.Pool{
+		New: func() interface{} {
+			enc, _ := zstd.NewWriter(io.Discard)
+			return enc
+		},
+	}
+)
+
+// parseCompressionPreference turns a comma separated env var value into an
+// ordered list of content codings, falling back to defaultEncodingPreference
+// when raw is empty.
+func parseCompressionPreference(raw string) []string {
+	if raw == "" {
+		return defaultEncodingPreference
+	}
+
+	var preference []string
+	for _, enc := range strings.Split(raw, ",") {
+		enc = strings.TrimSpace(enc)
+		if enc != "" {
+			preference = append(preference, enc)
+		}
+	}
+	if len(preference) == 0 {
+		return defaultEncodingPreference
+	}
+	return preference
+}
+
+// negotiateEncoding picks the best content coding accepted by the client out
+// of preference, honoring q-values, "identity" and "*" the same way
+// parseAcceptHeader already does for the Accept header. It returns "" when
+// the client does not accept any of the codings in preference.
+func negotiateEncoding(header string, preference []string) string {
+	if header == "" {
+		return ""
+	}
+
+	for _, coding := range parseAcceptHeader(header) {
+		switch coding {
+		case "identity":
+			return ""
+		case "*":
+			if len(preference) > 0 {
+				return preference[0]
+			}
+		default:
+			for _, enc := range preference {
+				if enc == coding {
+					return enc
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// nopWriteCloser adapts an io.Writer into an io.WriteCloser whose Close is a
+// no-op, used for the identity (uncompressed) coding.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressWriter wraps w with the encoder for encoding, returning a
+// WriteCloser that must be closed by the caller to flush the compressed
+// output, and a release func that returns the underlying encoder to its pool
+// and must be called after Close.
+func compressWriter(w io.Writer, encoding string) (io.WriteCloser, func()) {
+	switch encoding {
+	case "gzip":
+		gw := gzipWriterPool.Get().(*gzip.Writer)
+		gw.Reset(w)
+		return gw, func() { gzipWriterPool.Put(gw) }
+	case "deflate":
+		fw := deflateWriterPool.Get().(*flate.Writer)
+		fw.Reset(w)
+		return fw, func() { deflateWriterPool.Put(fw) }
+	case "br":
+		bw := brotliWriterPool.Get().(*brotli.Writer)
+		bw.Reset(w)
+		return bw, func() { brotliWriterPool.Put(bw) }
+	case "zstd":
+		zw := zstdEncoderPool.Get().(*zstd.Encoder)
+		zw.Reset(w)
+		return zw, func() { zstdEncoderPool.Put(zw) }
+	default:
+		return nopWriteCloser{w}, func() {}
+	}
+}
+
+// compressBytes encodes data with the encoder for encoding, returning the
+// result as a standalone buffer suitable for caching.
+func compressBytes(data []byte, encoding string) ([]byte, error) {
+	var buf bytes.Buffer
+	cw, release := compressWriter(&buf, encoding)
+	defer release()
+
+	if _, err := cw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCompressionPreference(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []string
+	}{
+		{"empty falls back to default", "", defaultEncodingPreference},
+		{"single value", "gzip", []string{"gzip"}},
+		{"trims whitespace around entries", " br , gzip ", []string{"br", "gzip"}},
+		{"drops empty entries", "gzip,,br", []string{"gzip", "br"}},
+		{"all entries empty falls back to default", " , ", defaultEncodingPreference},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseCompressionPreference(tt.raw)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCompressionPreference(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	preference := []string{"zstd", "br", "gzip", "deflate"}
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"no header means no encoding", "", ""},
+		{"picks client's first accepted coding that the server supports", "gzip, br", "gzip"},
+		{"respects q-values over list order", "gzip;q=0.2, deflate;q=0.8", "deflate"},
+		{"identity means no encoding", "identity", ""},
+		{"wildcard picks most preferred overall", "*", "zstd"},
+		{"unsupported coding yields no encoding", "compress", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := negotiateEncoding(tt.header, preference)
+			if got != tt.want {
+				t.Errorf("negotiateEncoding(%q, %v) = %q, want %q", tt.header, preference, got, tt.want)
+			}
+		})
+	}
+}
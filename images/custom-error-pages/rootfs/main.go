@@ -17,19 +17,23 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
-	"io"
 	"log"
 	"mime"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
-	"sort"
+)
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+// version and gitCommit are populated at build time via -ldflags and
+// reported through the build_info metric.
+var (
+	version   = "dev"
+	gitCommit = "unknown"
 )
 
 const (
@@ -71,11 +75,6 @@ const (
 	DefaultFormatVar = "DEFAULT_RESPONSE_FORMAT"
 )
 
-func init() {
-	prometheus.MustRegister(requestCount)
-	prometheus.MustRegister(requestDuration)
-}
-
 func main() {
 	errFilesPath := "/www"
 	if os.Getenv(ErrFilesPathVar) != "" {
@@ -87,80 +86,94 @@ func main() {
 		defaultFormat = os.Getenv(DefaultFormatVar)
 	}
 
-	http.HandleFunc("/", errorHandler(errFilesPath, defaultFormat))
+	encodingPreference := parseCompressionPreference(os.Getenv(CompressionPreferenceVar))
 
-	http.Handle("/metrics", promhttp.Handler())
+	cacheSize := defaultErrorCacheSize
+	if v := os.Getenv(ErrorCacheSizeVar); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			cacheSize = n
+		} else {
+			log.Printf("invalid %v value %q, using default of %v", ErrorCacheSizeVar, v, cacheSize)
+		}
+	}
 
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
+	cache := newErrorFileCache(cacheSize)
+	prewarm(cache, errFilesPath)
+
+	tc := newTemplateCache()
+	watchErrorFiles(cache, tc, errFilesPath)
+
+	localesPath := errFilesPath + "/locales"
+	if v := os.Getenv(ErrorLocalesPathVar); v != "" {
+		localesPath = v
+	}
+	locales := loadLocales(localesPath)
+	extraVars := buildExtraVars()
 
-	http.ListenAndServe(fmt.Sprintf(":8080"), nil)
+	mux := http.NewServeMux()
+	mux.Handle("/", instrumentHandler(errorHandler(errFilesPath, defaultFormat, encodingPreference, cache, tc, locales, extraVars)))
+
+	runServers(mux)
 }
 
 func parseAcceptHeader(header string) []string {
-    parts := strings.Split(header, ",")
-    typeQualityPairs := make([]struct {
-        mediaType string
-        quality   float64
-    }, len(parts))
-
-    for i, part := range parts {
-        part = strings.TrimSpace(part)
-        mediaType := part
-        quality := 1.0
-
-        if qIndex := strings.Index(part, ";q="); qIndex != -1 {
-            mediaType = part[:qIndex]
-            qValue := part[qIndex+3:]
-            if q, err := strconv.ParseFloat(qValue, 64); err == nil {
-                quality = q
-            }
-        }
-
-        typeQualityPairs[i] = struct {
-            mediaType string
-            quality   float64
-        }{mediaType, quality}
-    }
-
-    sort.Slice(typeQualityPairs, func(i, j int) bool {
-        return typeQualityPairs[i].quality > typeQualityPairs[j].quality
-    })
-
-    mediaTypes := make([]string, len(typeQualityPairs))
-    for i, pair := range typeQualityPairs {
-        mediaTypes[i] = pair.mediaType
-    }
-
-    return mediaTypes
+	parts := strings.Split(header, ",")
+	typeQualityPairs := make([]struct {
+		mediaType string
+		quality   float64
+	}, len(parts))
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		mediaType := part
+		quality := 1.0
+
+		if qIndex := strings.Index(part, ";q="); qIndex != -1 {
+			mediaType = part[:qIndex]
+			qValue := part[qIndex+3:]
+			if q, err := strconv.ParseFloat(qValue, 64); err == nil {
+				quality = q
+			}
+		}
+
+		typeQualityPairs[i] = struct {
+			mediaType string
+			quality   float64
+		}{mediaType, quality}
+	}
+
+	sort.Slice(typeQualityPairs, func(i, j int) bool {
+		return typeQualityPairs[i].quality > typeQualityPairs[j].quality
+	})
+
+	mediaTypes := make([]string, len(typeQualityPairs))
+	for i, pair := range typeQualityPairs {
+		mediaTypes[i] = pair.mediaType
+	}
+
+	return mediaTypes
 }
 
 func selectFormat(acceptHeader string, defaultFormat string) (string, string) {
-    mediaTypes := parseAcceptHeader(acceptHeader)
-    for _, mediaType := range mediaTypes {
-        if mediaType == "application/json" || mediaType == "text/html" {
-            cext, _ := mime.ExtensionsByType(mediaType)
-            if len(cext) > 0 {
-                return mediaType, cext[0]
-            }
-        }
-    }
-    cext, _ := mime.ExtensionsByType(defaultFormat)
-    return defaultFormat, cext[0]
+	mediaTypes := parseAcceptHeader(acceptHeader)
+	for _, mediaType := range mediaTypes {
+		if mediaType == "application/json" || mediaType == "text/html" {
+			cext, _ := mime.ExtensionsByType(mediaType)
+			if len(cext) > 0 {
+				return mediaType, cext[0]
+			}
+		}
+	}
+	cext, _ := mime.ExtensionsByType(defaultFormat)
+	return defaultFormat, cext[0]
 }
 
-func errorHandler(path, defaultFormat string) func(http.ResponseWriter, *http.Request) {
-	defaultExts, err := mime.ExtensionsByType(defaultFormat)
-	if err != nil || len(defaultExts) == 0 {
+func errorHandler(path, defaultFormat string, encodingPreference []string, cache *errorFileCache, tc *templateCache, locales map[string]localeBundle, extraVars map[string]string) http.Handler {
+	if defaultExts, err := mime.ExtensionsByType(defaultFormat); err != nil || len(defaultExts) == 0 {
 		panic("couldn't get file extension for default format")
 	}
-	defaultExt := defaultExts[0]
-
-	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		ext := defaultExt
 
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if os.Getenv("DEBUG") != "" {
 			w.Header().Set(FormatHeader, r.Header.Get(FormatHeader))
 			w.Header().Set(CodeHeader, r.Header.Get(CodeHeader))
@@ -174,35 +187,34 @@ func errorHandler(path, defaultFormat string) func(http.ResponseWriter, *http.Re
 		}
 
 		format := r.Header.Get(FormatHeader)
-	        var ext string
-	        if format == "" {
-	            acceptHeader := r.Header.Get("Accept")
-	            format, ext = selectFormat(acceptHeader, defaultFormat)
-	            log.Printf("Selected format: %v, extension: %v", format, ext)
-	        } else {
-	            cext, _ := mime.ExtensionsByType(format)
-	            if len(cext) > 0 {
-	                ext = cext[0]
-	            } else {
-	                format = defaultFormat
-	                cext, _ = mime.ExtensionsByType(defaultFormat)
-	                ext = cext[0]
-	            }
-	        }
-		
+		var ext string
+		if format == "" {
+			acceptHeader := r.Header.Get("Accept")
+			format, ext = selectFormat(acceptHeader, defaultFormat)
+			log.Printf("Selected format: %v, extension: %v", format, ext)
+		} else {
+			cext, _ := mime.ExtensionsByType(format)
+			if len(cext) > 0 {
+				ext = cext[0]
+			} else {
+				format = defaultFormat
+				cext, _ = mime.ExtensionsByType(defaultFormat)
+				ext = cext[0]
+			}
+		}
+
 		w.Header().Set(ContentType, format)
 
 		codeStr := r.Header.Get(CodeHeader)
-	        if codeStr == "" {
-	            codeStr = "404"
-	        }
-		
+		if codeStr == "" {
+			codeStr = "404"
+		}
+
 		code, err := strconv.Atoi(codeStr)
 		if err != nil {
 			code = 404
 			log.Printf("unexpected error reading return code: %v. Using %v", err, code)
 		}
-		w.WriteHeader(code)
 
 		if !strings.HasPrefix(ext, ".") {
 			ext = "." + ext
@@ -211,33 +223,72 @@ func errorHandler(path, defaultFormat string) func(http.ResponseWriter, *http.Re
 		if ext == ".htm" {
 			ext = ".html"
 		}
-		file := fmt.Sprintf("%v/%v%v", path, code, ext)
-		f, err := os.Open(file)
-		if err != nil {
-			log.Printf("unexpected error opening file: %v", err)
-			scode := strconv.Itoa(code)
-			file := fmt.Sprintf("%v/%cxx%v", path, scode[0], ext)
-			f, err := os.Open(file)
-			if err != nil {
-				log.Printf("unexpected error opening file: %v", err)
-				http.NotFound(w, r)
-				return
+
+		encoding := negotiateEncoding(r.Header.Get(EncodingHeader), encodingPreference)
+		w.Header().Set(VaryHeader, EncodingHeader)
+
+		locale := selectLocale(r.Header.Get("Accept-Language"), locales)
+		ctx := errorPageContext{
+			Code:        code,
+			CodeText:    http.StatusText(code),
+			Format:      format,
+			OriginalURI: r.Header.Get(OriginalURI),
+			Namespace:   r.Header.Get(Namespace),
+			IngressName: r.Header.Get(IngressName),
+			ServiceName: r.Header.Get(ServiceName),
+			ServicePort: r.Header.Get(ServicePort),
+			RequestID:   r.Header.Get(RequestId),
+			Now:         time.Now(),
+			Extra:       extraVars,
+		}
+
+		// Resolution order is exact-code first (template, then static),
+		// only falling back to the NxX class (template, then static) if
+		// neither exists, so a class-level catch-all template never
+		// shadows a more specific, pre-existing static page.
+		scode := strconv.Itoa(code)
+		classStr := string(scode[0]) + "xx"
+		class := code / 100 * 100
+
+		served := servedNotFound
+		var tmplFile, file, resolvedEncoding string
+		var entry *cacheEntry
+
+		if f, ok := findTemplateFile(path, scode, ext, format); ok {
+			tmplFile, served = f, servedTemplate
+		} else if e, enc, ok := cache.loadEntry(cacheKey{code: code, ext: ext, encoding: encoding}, fmt.Sprintf("%v/%v%v", path, code, ext)); ok {
+			entry, resolvedEncoding, file, served = e, enc, fmt.Sprintf("%v/%v%v", path, code, ext), servedExact
+		} else if f, ok := findTemplateFile(path, classStr, ext, format); ok {
+			tmplFile, served = f, servedTemplateClassFallback
+		} else if e, enc, ok := cache.loadEntry(cacheKey{code: class, ext: ext, encoding: encoding}, fmt.Sprintf("%v/%v%v", path, classStr, ext)); ok {
+			entry, resolvedEncoding, file, served = e, enc, fmt.Sprintf("%v/%v%v", path, classStr, ext), servedClassFallback
+		}
+
+		if served == servedNotFound {
+			log.Printf("unexpected error opening file for code %v and format %v", code, format)
+			http.NotFound(w, r)
+			errorResponsesTotal.WithLabelValues(strconv.Itoa(http.StatusNotFound), format, r.Header.Get(IngressName), r.Header.Get(Namespace), served).Inc()
+			return
+		}
+
+		if tmplFile != "" {
+			log.Printf("rendering templated error response for code %v and format %v from file %v", code, format, tmplFile)
+			if err := renderTemplate(w, tc, tmplFile, format, ctx, locale, locales[locale], encoding); err != nil {
+				log.Printf("unexpected error rendering template %v: %v", tmplFile, err)
 			}
-			defer f.Close()
-			log.Printf("serving custom error response for code %v and format %v from file %v", code, format, file)
-			io.Copy(w, f)
+			errorResponsesTotal.WithLabelValues(strconv.Itoa(code), format, r.Header.Get(IngressName), r.Header.Get(Namespace), served).Inc()
 			return
 		}
-		defer f.Close()
-		log.Printf("serving custom error response for code %v and format %v from file %v", code, format, file)
-		io.Copy(w, f)
 
-		duration := time.Now().Sub(start).Seconds()
+		if resolvedEncoding != "" {
+			w.Header().Set(ContentEncodingHeader, resolvedEncoding)
+		}
+		w.Header().Set("Etag", entry.etag)
 
-		proto := strconv.Itoa(r.ProtoMajor)
-		proto = fmt.Sprintf("%s.%s", proto, strconv.Itoa(r.ProtoMinor))
+		log.Printf("serving custom error response for code %v and format %v from file %v", code, format, file)
+		ocw := &codeOverrideWriter{ResponseWriter: w, code: code}
+		http.ServeContent(ocw, r, file, entry.modTime, bytes.NewReader(entry.data))
 
-		requestCount.WithLabelValues(proto).Inc()
-		requestDuration.WithLabelValues(proto).Observe(duration)
-	}
+		errorResponsesTotal.WithLabelValues(strconv.Itoa(code), format, r.Header.Get(IngressName), r.Header.Get(Namespace), served).Inc()
+	})
 }
@@ -0,0 +1,129 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func newTestErrorHandler(dir string) http.Handler {
+	return errorHandler(dir, "text/html", defaultEncodingPreference, newErrorFileCache(defaultErrorCacheSize), newTemplateCache(), nil, nil)
+}
+
+func TestErrorHandlerServesExactCodeMatch(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "404.html"), "<html>exact 404</html>")
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(CodeHeader, "404")
+	req.Header.Set(FormatHeader, "text/html")
+
+	newTestErrorHandler(dir).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status = %v, want %v", rr.Code, http.StatusNotFound)
+	}
+	if got := rr.Body.String(); got != "<html>exact 404</html>" {
+		t.Errorf("body = %q, want exact 404.html content", got)
+	}
+}
+
+func TestErrorHandlerFallsBackToClassWhenExactMissing(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "4xx.html"), "<html>4xx fallback</html>")
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(CodeHeader, "404")
+	req.Header.Set(FormatHeader, "text/html")
+
+	newTestErrorHandler(dir).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status = %v, want %v", rr.Code, http.StatusNotFound)
+	}
+	if got := rr.Body.String(); got != "<html>4xx fallback</html>" {
+		t.Errorf("body = %q, want class fallback content", got)
+	}
+}
+
+func TestErrorHandlerPrefersTemplateOverStaticForSameCode(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "404.html"), "<html>static 404</html>")
+	write(t, filepath.Join(dir, "404.gohtml"), "<html>templated {{.Code}}</html>")
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(CodeHeader, "404")
+	req.Header.Set(FormatHeader, "text/html")
+
+	newTestErrorHandler(dir).ServeHTTP(rr, req)
+
+	if got := rr.Body.String(); got != "<html>templated 404</html>" {
+		t.Errorf("body = %q, want rendered template content", got)
+	}
+}
+
+func TestErrorHandlerNotFoundWhenNothingMatches(t *testing.T) {
+	dir := t.TempDir()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(CodeHeader, "404")
+	req.Header.Set(FormatHeader, "text/html")
+
+	newTestErrorHandler(dir).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status = %v, want %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestErrorHandlerCompressedResponseContentEncodingMatchesBody(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "404.html"), "<html>exact 404</html>")
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(CodeHeader, "404")
+	req.Header.Set(FormatHeader, "text/html")
+	req.Header.Set(EncodingHeader, "gzip")
+
+	newTestErrorHandler(dir).ServeHTTP(rr, req)
+
+	if got := rr.Header().Get(ContentEncodingHeader); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want %q", got, "gzip")
+	}
+
+	gr, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("body was not valid gzip despite Content-Encoding: gzip: %v", err)
+	}
+	decoded, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("reading gzip body: %v", err)
+	}
+	if got := string(decoded); got != "<html>exact 404</html>" {
+		t.Errorf("decoded body = %q, want exact 404.html content", got)
+	}
+}
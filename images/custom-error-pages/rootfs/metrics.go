@@ -17,39 +17,129 @@ limitations under the License.
 package main
 
 import (
-    "github.com/prometheus/client_golang/prometheus"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "default_http_backend"
+	subsystem = "http"
 )
 
+// servedFrom classifies how an error response was satisfied, for the
+// "served" label on errorResponsesTotal.
 const (
-    namespace = "default_http_backend"
-    subsystem = "http"
+	servedExact                 = "exact"
+	servedClassFallback         = "class_fallback"
+	servedTemplate              = "template"
+	servedTemplateClassFallback = "template_class_fallback"
+	servedNotFound              = "not_found"
 )
 
 var (
-    requestCount = prometheus.NewCounterVec(
-        prometheus.CounterOpts{
-            Namespace: namespace,
-            Subsystem: subsystem,
-            Name:      "request_count_total",
-            Help:      "Total number of HTTP requests made.",
-        },
-        []string{"proto"},
-    )
-
-    requestDuration = prometheus.NewHistogramVec(
-        prometheus.HistogramOpts{
-            Namespace: namespace,
-            Subsystem: subsystem,
-            Name:      "request_duration_seconds",
-            Help:      "Histogram of the duration (in seconds) of HTTP requests.",
-            Buckets:   prometheus.DefBuckets,
-        },
-        []string{"proto"},
-    )
+	// registry is a dedicated registry rather than the global default so the
+	// admin mux can expose exactly the metrics this binary collects, without
+	// pulling in whatever else happens to register against
+	// prometheus.DefaultRegisterer.
+	registry = prometheus.NewRegistry()
+
+	// requestCount, requestDuration and responseSize are populated by the
+	// promhttp instrumentation middleware chain in instrumentHandler, which
+	// fills in the "code" and "method" labels from the real status code and
+	// request method.
+	requestCount = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_count_total",
+			Help:      "Total number of HTTP requests made.",
+		},
+		[]string{"code", "method"},
+	)
+
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "request_duration_seconds",
+			Help:      "Histogram of the duration (in seconds) of HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"code", "method"},
+	)
+
+	responseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "response_size_bytes",
+			Help:      "Histogram of the size (in bytes) of HTTP responses.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+		},
+		[]string{"code", "method"},
+	)
+
+	requestsInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_in_flight",
+			Help:      "Current number of HTTP requests being served.",
+		},
+	)
+
+	// errorResponsesTotal carries the dimensions an operator actually cares
+	// about for an error backend that promhttp's generic code/method labels
+	// cannot express: the negotiated format, the Ingress/namespace the
+	// request came through, and whether a file specific to the status code
+	// was served, a class fallback (NxX) kicked in, or nothing was found.
+	errorResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "error_responses_total",
+			Help:      "Total number of error responses served, by code, format, ingress, namespace and how the response was resolved.",
+		},
+		[]string{"code", "format", "ingress", "namespace", "served"},
+	)
+
+	buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "build_info",
+			Help:      "A metric with a constant '1' value labeled by version and git commit from which the binary was built.",
+		},
+		[]string{"version", "git_commit"},
+	)
 )
 
 func init() {
-    // Register the metrics with Prometheus
-    prometheus.MustRegister(requestCount)
-    prometheus.MustRegister(requestDuration)
+	registry.MustRegister(requestCount)
+	registry.MustRegister(requestDuration)
+	registry.MustRegister(responseSize)
+	registry.MustRegister(requestsInFlight)
+	registry.MustRegister(errorResponsesTotal)
+	registry.MustRegister(buildInfo)
+	registry.MustRegister(prometheus.NewGoCollector())
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	buildInfo.WithLabelValues(version, gitCommit).Set(1)
+}
+
+// metricsHandler exposes the metrics registered against registry.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// instrumentHandler wraps next with the standard promhttp middleware chain,
+// recording in-flight requests, total requests, request duration and
+// response size labeled by the real status code next writes.
+func instrumentHandler(next http.Handler) http.Handler {
+	h := promhttp.InstrumentHandlerInFlight(requestsInFlight, next)
+	h = promhttp.InstrumentHandlerDuration(requestDuration, h)
+	h = promhttp.InstrumentHandlerResponseSize(responseSize, h)
+	h = promhttp.InstrumentHandlerCounter(requestCount, h)
+	return h
 }
@@ -0,0 +1,55 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentHandlerRecordsRequestCount(t *testing.T) {
+	before := testutil.ToFloat64(requestCount.WithLabelValues("200", "get"))
+
+	h := instrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	after := testutil.ToFloat64(requestCount.WithLabelValues("200", "get"))
+	if after != before+1 {
+		t.Errorf("requestCount{code=200,method=get} = %v, want %v", after, before+1)
+	}
+}
+
+func TestBuildInfoUsesSnakeCaseGitCommitLabel(t *testing.T) {
+	rr := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body := rr.Body.String()
+	if !strings.Contains(body, `git_commit="`) {
+		t.Errorf("expected build_info to carry a git_commit label, got body:\n%s", body)
+	}
+	if strings.Contains(body, `gitCommit="`) {
+		t.Errorf("build_info still carries the old camelCase gitCommit label, got body:\n%s", body)
+	}
+}
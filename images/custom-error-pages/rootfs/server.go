@@ -0,0 +1,160 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	_ "net/http/pprof" // registers its handlers on http.DefaultServeMux, served only via DebugAddrVar
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// AdminAddrVar is the name of the environment variable holding the
+	// listen address for the admin mux (/metrics, /healthz, /readyz), kept
+	// off the data-plane listener so scraping and probes never compete
+	// with error responses.
+	AdminAddrVar = "ADMIN_ADDR"
+
+	// DebugAddrVar is the name of the environment variable holding the
+	// listen address for the net/http/pprof debug mux. pprof is only
+	// served when this is set, and always on its own listener.
+	DebugAddrVar = "DEBUG_ADDR"
+
+	// ShutdownGracePeriodVar is the name of the environment variable
+	// holding how long to wait for in-flight requests to drain after a
+	// SIGTERM/SIGINT before the listeners are forced closed.
+	ShutdownGracePeriodVar = "SHUTDOWN_GRACE_PERIOD"
+
+	defaultAdminAddr           = ":8081"
+	defaultShutdownGracePeriod = 10 * time.Second
+
+	readHeaderTimeout = 5 * time.Second
+	idleTimeout       = 60 * time.Second
+)
+
+// ready reports whether the process should be considered ready to receive
+// traffic. It is flipped false as soon as a shutdown signal is received, just
+// before the data-plane server's Shutdown is called, so /readyz reports 503
+// while in-flight requests drain. No delay is inserted between the two: the
+// data-plane listener stops accepting connections right away.
+var ready atomic.Bool
+
+func init() {
+	ready.Store(true)
+}
+
+// newAdminMux builds the mux served on AdminAddrVar: metrics plus the
+// liveness and readiness probes. /healthz always reports OK, since the
+// process is alive for as long as it can answer at all; /readyz reports 503
+// once shutdown has begun. The admin server is shut down only after the
+// data-plane server has drained.
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// runServers starts the data-plane server on :8080 with handler, the admin
+// server (metrics/healthz/readyz) on AdminAddrVar, and, if DebugAddrVar is
+// set, a pprof server on its own listener. It blocks until the data-plane
+// server stops, trapping SIGTERM/SIGINT to drain in-flight requests for up
+// to SHUTDOWN_GRACE_PERIOD before shutting the data-plane and admin servers
+// down. The pprof server is not shut down; it ends when the process exits.
+func runServers(handler http.Handler) {
+	dataSrv := &http.Server{
+		Addr:              ":8080",
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	adminAddr := defaultAdminAddr
+	if v := os.Getenv(AdminAddrVar); v != "" {
+		adminAddr = v
+	}
+	adminSrv := &http.Server{
+		Addr:              adminAddr,
+		Handler:           newAdminMux(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	go func() {
+		if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("admin server error: %v", err)
+		}
+	}()
+
+	if debugAddr := os.Getenv(DebugAddrVar); debugAddr != "" {
+		go func() {
+			log.Printf("pprof debug server listening on %v", debugAddr)
+			if err := http.ListenAndServe(debugAddr, nil); err != nil {
+				log.Printf("pprof debug server error: %v", err)
+			}
+		}()
+	}
+
+	gracePeriod := defaultShutdownGracePeriod
+	if v := os.Getenv(ShutdownGracePeriodVar); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			gracePeriod = d
+		} else {
+			log.Printf("invalid %v value %q, using default of %v", ShutdownGracePeriodVar, v, gracePeriod)
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-sig
+		log.Printf("received shutdown signal, draining for up to %v", gracePeriod)
+		ready.Store(false)
+
+		ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+		defer cancel()
+		if err := dataSrv.Shutdown(ctx); err != nil {
+			log.Printf("error during graceful shutdown: %v", err)
+		}
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			log.Printf("error shutting down admin server: %v", err)
+		}
+	}()
+
+	if err := dataSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("error starting server: %v", err)
+	}
+	// ListenAndServe returns as soon as Shutdown begins; wait for the drain.
+	<-shutdownDone
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminMuxHealthzAlwaysOK(t *testing.T) {
+	defer ready.Store(ready.Load())
+
+	ready.Store(false)
+
+	rr := httptest.NewRecorder()
+	newAdminMux().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("/healthz = %v, want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestAdminMuxReadyzReflectsReadyFlag(t *testing.T) {
+	defer ready.Store(ready.Load())
+
+	tests := []struct {
+		name  string
+		ready bool
+		want  int
+	}{
+		{"ready reports OK", true, http.StatusOK},
+		{"not ready reports unavailable", false, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready.Store(tt.ready)
+
+			rr := httptest.NewRecorder()
+			newAdminMux().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rr.Code != tt.want {
+				t.Errorf("/readyz with ready=%v = %v, want %v", tt.ready, rr.Code, tt.want)
+			}
+		})
+	}
+}
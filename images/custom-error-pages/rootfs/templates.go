@@ -0,0 +1,325 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ErrorLocalesPathVar is the name of the environment variable holding the
+	// directory of locale bundles (e.g. "en.json", "pt-br.yaml") consulted by
+	// the tr template func.
+	ErrorLocalesPathVar = "ERROR_LOCALES_PATH"
+
+	// ErrorTemplateExtraVarPrefix is the prefix stripped from environment
+	// variables exposed to templates via the Extra context field, e.g.
+	// ERROR_TMPL_SUPPORT_EMAIL becomes Extra["SUPPORT_EMAIL"].
+	ErrorTemplateExtraVarPrefix = "ERROR_TMPL_"
+
+	// defaultLocale is used by tr when the client's Accept-Language does not
+	// match any loaded bundle.
+	defaultLocale = "en"
+)
+
+// templateExtensions are the file suffixes that mark a file under
+// ERROR_FILES_PATH as a template rather than a static asset; watchErrorFiles
+// uses this list to know which changed files should invalidate tc instead
+// of (or in addition to) the byte cache.
+var templateExtensions = []string{".gohtml", ".tmpl"}
+
+// execTemplate is satisfied by both *html/template.Template and
+// *text/template.Template, letting the rest of this file treat them
+// interchangeably once parsed.
+type execTemplate interface {
+	Execute(wr io.Writer, data interface{}) error
+}
+
+// errorPageContext is the data made available to an error page template.
+type errorPageContext struct {
+	Code        int
+	CodeText    string
+	Format      string
+	OriginalURI string
+	Namespace   string
+	IngressName string
+	ServiceName string
+	ServicePort string
+	RequestID   string
+	Now         time.Time
+	Extra       map[string]string
+}
+
+// baseFuncMap holds the template funcs available at parse time. tr must be
+// present here (html/template rejects unknown function names at parse time)
+// but is rebound per render via Clone+Funcs to the locale negotiated for
+// that request; this placeholder is only ever seen if Clone is skipped.
+var baseFuncMap = template.FuncMap{
+	"safeURL": func(s string) template.URL { return template.URL(s) },
+	"lower":   strings.ToLower,
+	"title":   strings.Title, //nolint:staticcheck // no golang.org/x/text dependency in this image
+	"tr":      func(key string) string { return key },
+}
+
+// templateCache parses error page templates lazily and keeps them around
+// precompiled, so rendering an error page only costs an Execute call. Entries
+// are invalidated by the same fsnotify watcher that invalidates the byte
+// cache in cache.go, so operators can hot-swap templates too.
+type templateCache struct {
+	mu    sync.Mutex
+	tmpls map[string]execTemplate
+}
+
+func newTemplateCache() *templateCache {
+	return &templateCache{tmpls: make(map[string]execTemplate)}
+}
+
+func (c *templateCache) get(file string) (execTemplate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.tmpls[file]
+	return t, ok
+}
+
+func (c *templateCache) set(file string, t execTemplate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tmpls[file] = t
+}
+
+func (c *templateCache) invalidate(file string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tmpls, file)
+}
+
+// loadTemplate returns the parsed template for file, parsing and caching it
+// on a miss. format selects html/template (auto-escaped for HTML output) vs
+// text/template (everything else, e.g. application/json error pages).
+func (c *templateCache) loadTemplate(file, format string) (execTemplate, error) {
+	if t, ok := c.get(file); ok {
+		return t, nil
+	}
+
+	name := filepath.Base(file)
+	var t execTemplate
+	var err error
+	if format == "text/html" {
+		t, err = template.New(name).Funcs(baseFuncMap).ParseFiles(file)
+	} else {
+		t, err = texttemplate.New(name).Funcs(baseFuncMap).ParseFiles(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.set(file, t)
+	return t, nil
+}
+
+// findTemplateFile looks for a template error page for codeStr/ext/format
+// under path. The lookup is format-aware so a single template file is never
+// reused to answer a different negotiated format (e.g. serving un-escaped,
+// HTML-flavored markup under a "application/json" Content-Type): the bare
+// "<codeStr>.gohtml" shorthand (e.g. "404.gohtml", or "4xx.gohtml" for the
+// class fallback) only matches when format is "text/html"; every other
+// format must provide the explicit sibling "<codeStr><ext>.tmpl" next to the
+// static asset it replaces (e.g. "404.json.tmpl"), a form HTML may use too
+// (e.g. "404.html.tmpl"). The shorthand wins when both exist.
+func findTemplateFile(path, codeStr, ext, format string) (string, bool) {
+	if format == "text/html" {
+		f := fmt.Sprintf("%v/%v.gohtml", path, codeStr)
+		if fileExists(f) {
+			return f, true
+		}
+	}
+
+	f := fmt.Sprintf("%v/%v%v.tmpl", path, codeStr, ext)
+	if fileExists(f) {
+		return f, true
+	}
+
+	return "", false
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// localeBundle maps translation keys to the localized string for one locale.
+type localeBundle map[string]string
+
+// loadLocales scans path for "<locale>.json"/"<locale>.yaml"/"<locale>.yml"
+// bundles and returns them keyed by locale tag (e.g. "en", "pt-br"). It is
+// called once at startup; a missing or unreadable directory just means no
+// translations are available and tr falls back to echoing the lookup key.
+func loadLocales(path string) map[string]localeBundle {
+	bundles := make(map[string]localeBundle)
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		log.Printf("no error page locale bundles loaded from %v: %v", path, err)
+		return bundles
+	}
+
+	for _, de := range entries {
+		if de.IsDir() {
+			continue
+		}
+
+		name := de.Name()
+		ext := filepath.Ext(name)
+		locale := strings.ToLower(strings.TrimSuffix(name, ext))
+
+		data, err := os.ReadFile(filepath.Join(path, name))
+		if err != nil {
+			log.Printf("unexpected error reading locale bundle %v: %v", name, err)
+			continue
+		}
+
+		bundle := make(localeBundle)
+		switch ext {
+		case ".json":
+			err = json.Unmarshal(data, &bundle)
+		case ".yaml", ".yml":
+			err = yaml.Unmarshal(data, &bundle)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("unexpected error parsing locale bundle %v: %v", name, err)
+			continue
+		}
+
+		bundles[locale] = bundle
+	}
+
+	return bundles
+}
+
+// selectLocale picks the best locale in bundles for header, reusing the same
+// q-value ordering parseAcceptHeader already applies to the Accept header.
+// It matches the full tag first (e.g. "pt-br"), then its primary subtag
+// (e.g. "pt"), falling back to defaultLocale and finally "" (no bundle, tr
+// echoes its argument unchanged).
+func selectLocale(header string, bundles map[string]localeBundle) string {
+	for _, tag := range parseAcceptHeader(header) {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "*" {
+			continue
+		}
+		if _, ok := bundles[tag]; ok {
+			return tag
+		}
+		if primary := strings.SplitN(tag, "-", 2)[0]; primary != tag {
+			if _, ok := bundles[primary]; ok {
+				return primary
+			}
+		}
+	}
+
+	if _, ok := bundles[defaultLocale]; ok {
+		return defaultLocale
+	}
+	return ""
+}
+
+// renderTemplate executes the template for file with the given context,
+// translating through the bundle for locale, and writes the (optionally
+// compressed) result to w with the status code the context carries.
+func renderTemplate(w http.ResponseWriter, tc *templateCache, file, format string, ctx errorPageContext, locale string, bundle localeBundle, encoding string) error {
+	base, err := tc.loadTemplate(file, format)
+	if err != nil {
+		return err
+	}
+
+	tr := func(key string) string {
+		if v, ok := bundle[key]; ok {
+			return v
+		}
+		return key
+	}
+
+	var t execTemplate
+	switch tmpl := base.(type) {
+	case *template.Template:
+		clone, err := tmpl.Clone()
+		if err != nil {
+			return err
+		}
+		t = clone.Funcs(template.FuncMap{"tr": tr})
+	case *texttemplate.Template:
+		clone, err := tmpl.Clone()
+		if err != nil {
+			return err
+		}
+		t = clone.Funcs(texttemplate.FuncMap{"tr": tr})
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, ctx); err != nil {
+		return err
+	}
+
+	if encoding != "" {
+		w.Header().Set(ContentEncodingHeader, encoding)
+	}
+	w.WriteHeader(ctx.Code)
+
+	cw, release := compressWriter(w, encoding)
+	_, werr := cw.Write(buf.Bytes())
+	cerr := cw.Close()
+	release()
+	if werr != nil {
+		return werr
+	}
+	return cerr
+}
+
+// buildExtraVars collects the ERROR_TMPL_-prefixed environment variables
+// exposed to templates as Extra, stripping the prefix from each key, e.g.
+// ERROR_TMPL_SUPPORT_EMAIL becomes Extra["SUPPORT_EMAIL"].
+func buildExtraVars() map[string]string {
+	extra := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, ErrorTemplateExtraVarPrefix) {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimPrefix(parts[0], ErrorTemplateExtraVarPrefix)
+		extra[key] = parts[1]
+	}
+	return extra
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindTemplateFileIsFormatAware(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "404.gohtml"), "<html>404</html>")
+	write(t, filepath.Join(dir, "410.json.tmpl"), `{"code":{{.Code}}}`)
+
+	tests := []struct {
+		name     string
+		codeStr  string
+		ext      string
+		format   string
+		wantOK   bool
+		wantFile string
+	}{
+		{"gohtml shorthand matches text/html", "404", ".html", "text/html", true, filepath.Join(dir, "404.gohtml")},
+		{"gohtml shorthand does not leak into other formats", "404", ".json", "application/json", false, ""},
+		{"format-specific sibling matches its own format", "410", ".json", "application/json", true, filepath.Join(dir, "410.json.tmpl")},
+		{"format-specific sibling does not match a different format", "410", ".html", "text/html", false, ""},
+		{"missing code has no template", "499", ".html", "text/html", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := findTemplateFile(dir, tt.codeStr, tt.ext, tt.format)
+			if ok != tt.wantOK || got != tt.wantFile {
+				t.Errorf("findTemplateFile(%q, %q, %q) = (%q, %v), want (%q, %v)",
+					tt.codeStr, tt.ext, tt.format, got, ok, tt.wantFile, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSelectLocale(t *testing.T) {
+	bundles := map[string]localeBundle{
+		"en":    {"greeting": "hello"},
+		"pt-br": {"greeting": "oi"},
+		"es":    {"greeting": "hola"},
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"exact tag match", "pt-br", "pt-br"},
+		{"primary subtag match when the full tag is unavailable", "es-MX, es;q=0.9", "es"},
+		{"falls back to default locale", "fr", "en"},
+		{"empty header falls back to default locale", "", "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectLocale(tt.header, bundles)
+			if got != tt.want {
+				t.Errorf("selectLocale(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateTranslatesAndEscapes(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "404.gohtml"), `<p>{{.OriginalURI}}</p><p>{{tr "greeting"}}</p>`)
+
+	tc := newTemplateCache()
+	ctx := errorPageContext{Code: 404, OriginalURI: `<script>alert(1)</script>`}
+	bundle := localeBundle{"greeting": "hello"}
+
+	rr := httptest.NewRecorder()
+	if err := renderTemplate(rr, tc, filepath.Join(dir, "404.gohtml"), "text/html", ctx, "en", bundle, ""); err != nil {
+		t.Fatalf("renderTemplate returned error: %v", err)
+	}
+
+	body := rr.Body.String()
+	if strings.Contains(body, "<script>") {
+		t.Errorf("expected attacker-controlled OriginalURI to be HTML-escaped, got body:\n%s", body)
+	}
+	if !strings.Contains(body, "hello") {
+		t.Errorf("expected tr to resolve \"greeting\" from the locale bundle, got body:\n%s", body)
+	}
+}
+
+func write(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing %v: %v", path, err)
+	}
+}